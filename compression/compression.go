@@ -0,0 +1,130 @@
+// Package compression is the single codec implementation shared by the
+// worker's shm payloads and statestore's log entries: both encode a
+// single-byte codec prefix so decoders can auto-detect it without any
+// out-of-band configuration, which keeps old uncompressed payloads
+// readable forever.
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+type Codec byte
+
+const (
+	Raw  Codec = 0x00
+	LZ4  Codec = 0x01
+	Zstd Codec = 0x02
+)
+
+// DefaultThreshold is the smallest payload worth paying compression
+// overhead for.
+const DefaultThreshold = 4 * 1024
+
+// zstdEncoder and zstdDecoder are expensive to stand up (they spin up
+// internal goroutines) and are safe for concurrent use, so Compress and
+// Decompress share one of each across all callers instead of allocating a
+// fresh one per payload.
+var (
+	zstdEncoderOnce sync.Once
+	zstdEncoder     *zstd.Encoder
+	zstdEncoderErr  error
+
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+)
+
+func getZstdEncoder() (*zstd.Encoder, error) {
+	zstdEncoderOnce.Do(func() {
+		zstdEncoder, zstdEncoderErr = zstd.NewWriter(nil)
+	})
+	return zstdEncoder, zstdEncoderErr
+}
+
+func getZstdDecoder() (*zstd.Decoder, error) {
+	zstdDecoderOnce.Do(func() {
+		zstdDecoder, zstdDecoderErr = zstd.NewReader(nil)
+	})
+	return zstdDecoder, zstdDecoderErr
+}
+
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "", "none", "raw":
+		return Raw, nil
+	case "lz4":
+		return LZ4, nil
+	case "zstd":
+		return Zstd, nil
+	default:
+		return Raw, fmt.Errorf("Unknown compression codec %q", name)
+	}
+}
+
+// Compress prefixes data with a codec byte, compressing it with codec only
+// when it is larger than threshold; small payloads are kept raw since the
+// codec overhead would outweigh the savings.
+func Compress(codec Codec, threshold int, data []byte) ([]byte, error) {
+	if codec == Raw || len(data) <= threshold {
+		return append([]byte{byte(Raw)}, data...), nil
+	}
+	switch codec {
+	case LZ4:
+		var buf bytes.Buffer
+		buf.WriteByte(byte(LZ4))
+		w := lz4.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		enc, err := getZstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(data, []byte{byte(Zstd)}), nil
+	default:
+		return nil, fmt.Errorf("Unknown compression codec %d", codec)
+	}
+}
+
+// Decompress reads the codec prefix written by Compress and returns the
+// original bytes, regardless of which codec (or none) produced the
+// payload. A payload whose first byte is not a known codec is one written
+// before codec prefixes existed and is returned unchanged; a legacy payload
+// that happens to start with 0x00, 0x01 or 0x02 is read as prefixed.
+func Decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	codec := Codec(data[0])
+	payload := data[1:]
+	switch codec {
+	case Raw:
+		return payload, nil
+	case LZ4:
+		r := lz4.NewReader(bytes.NewReader(payload))
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(r); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		dec, err := getZstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(payload, nil)
+	default:
+		return data, nil
+	}
+}
@@ -0,0 +1,67 @@
+package compression
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressDecompressRoundTrip(t *testing.T) {
+	small := []byte("short payload")
+	large := bytes.Repeat([]byte("x"), DefaultThreshold+1)
+
+	for _, codec := range []Codec{Raw, LZ4, Zstd} {
+		for _, data := range [][]byte{small, large} {
+			encoded, err := Compress(codec, DefaultThreshold, data)
+			if err != nil {
+				t.Fatalf("Compress(%v) failed: %v", codec, err)
+			}
+			decoded, err := Decompress(encoded)
+			if err != nil {
+				t.Fatalf("Decompress(%v) failed: %v", codec, err)
+			}
+			if !bytes.Equal(decoded, data) {
+				t.Fatalf("round trip mismatch for codec=%v len=%d", codec, len(data))
+			}
+		}
+	}
+}
+
+func TestCompressBelowThresholdStaysRaw(t *testing.T) {
+	data := []byte("small")
+	encoded, err := Compress(Zstd, DefaultThreshold, data)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	if encoded[0] != byte(Raw) {
+		t.Fatalf("expected payload below threshold to stay raw, got codec byte %d", encoded[0])
+	}
+}
+
+func TestDecompressOldUncompressedPayload(t *testing.T) {
+	// Entries written before this codec existed have no prefix byte at
+	// all; decoders must return them unchanged.
+	data := []byte("legacy")
+	decoded, err := Decompress(data)
+	if err != nil {
+		t.Fatalf("Decompress failed: %v", err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Fatalf("got %q, want %q", decoded, data)
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	cases := map[string]Codec{"": Raw, "none": Raw, "raw": Raw, "lz4": LZ4, "zstd": Zstd}
+	for name, want := range cases {
+		got, err := ParseCodec(name)
+		if err != nil {
+			t.Fatalf("ParseCodec(%q) failed: %v", name, err)
+		}
+		if got != want {
+			t.Fatalf("ParseCodec(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if _, err := ParseCodec("bogus"); err == nil {
+		t.Fatalf("expected error for unknown codec name")
+	}
+}
@@ -0,0 +1,159 @@
+// Package logging provides a small leveled logger used in place of the
+// standard library's log package across the worker and statestore code.
+// Verbosity is controlled entirely through the environment so it can be
+// tuned per deployment without a config file:
+//
+//	BOKI_LOG_LEVEL=debug        // trace, debug, info, warn, error, fatal (default: info); trace acts like debug
+//	BOKI_LOG_TRACE=shm,gateway  // comma-separated component names to trace, regardless of BOKI_LOG_LEVEL
+//
+// Tracef lines are emitted only for components named in BOKI_LOG_TRACE.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelTrace Level = iota
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelTrace:
+		return "TRACE"
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	case LevelFatal:
+		return "FATAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func parseLevel(s string) Level {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "TRACE":
+		return LevelTrace
+	case "DEBUG":
+		return LevelDebug
+	case "WARN", "WARNING":
+		return LevelWarn
+	case "ERROR":
+		return LevelError
+	case "FATAL":
+		return LevelFatal
+	default:
+		return LevelInfo
+	}
+}
+
+func parseTraceComponents(s string) map[string]bool {
+	components := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			components[name] = true
+		}
+	}
+	return components
+}
+
+var (
+	minLevel        = parseLevel(os.Getenv("BOKI_LOG_LEVEL"))
+	traceComponents = parseTraceComponents(os.Getenv("BOKI_LOG_TRACE"))
+)
+
+// Fields carries per-call context (funcId, callId, clientId, seqNum, ...)
+// that is appended to every log line, so traces stay filterable in
+// multi-tenant deployments.
+type Fields map[string]interface{}
+
+func (f Fields) format() string {
+	if len(f) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(f))
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, f[k]))
+	}
+	return " " + strings.Join(parts, " ")
+}
+
+// Logger is scoped to one component (e.g. "shm", "gateway", "watchdog",
+// "statestore"), which is both logged with every line and matched against
+// BOKI_LOG_TRACE to decide whether Tracef is enabled for it.
+type Logger struct {
+	component string
+}
+
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+func (l *Logger) enabled(level Level) bool {
+	if level == LevelTrace {
+		return traceComponents[l.component]
+	}
+	return level >= minLevel
+}
+
+func (l *Logger) log(level Level, fields Fields, format string, args ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s [%s] [%s]%s %s\n",
+		time.Now().Format(time.RFC3339Nano), level, l.component, fields.format(), fmt.Sprintf(format, args...))
+	if level == LevelFatal {
+		os.Exit(1)
+	}
+}
+
+func (l *Logger) Tracef(fields Fields, format string, args ...interface{}) {
+	l.log(LevelTrace, fields, format, args...)
+}
+
+func (l *Logger) Debugf(fields Fields, format string, args ...interface{}) {
+	l.log(LevelDebug, fields, format, args...)
+}
+
+func (l *Logger) Infof(fields Fields, format string, args ...interface{}) {
+	l.log(LevelInfo, fields, format, args...)
+}
+
+func (l *Logger) Warnf(fields Fields, format string, args ...interface{}) {
+	l.log(LevelWarn, fields, format, args...)
+}
+
+func (l *Logger) Errorf(fields Fields, format string, args ...interface{}) {
+	l.log(LevelError, fields, format, args...)
+}
+
+// Fatalf logs at LevelFatal and terminates the process. Callers in
+// worker.go and statestore no longer reach for this on recoverable
+// per-call errors (e.g. a single shm copy-length mismatch) — it is
+// reserved for genuinely unrecoverable startup/process-level failures.
+func (l *Logger) Fatalf(fields Fields, format string, args ...interface{}) {
+	l.log(LevelFatal, fields, format, args...)
+}
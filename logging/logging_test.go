@@ -0,0 +1,64 @@
+package logging
+
+import "testing"
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]Level{
+		"trace":   LevelTrace,
+		"TRACE":   LevelTrace,
+		" debug ": LevelDebug,
+		"warn":    LevelWarn,
+		"warning": LevelWarn,
+		"error":   LevelError,
+		"fatal":   LevelFatal,
+		"":        LevelInfo,
+		"bogus":   LevelInfo,
+	}
+	for s, want := range cases {
+		if got := parseLevel(s); got != want {
+			t.Errorf("parseLevel(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestParseTraceComponents(t *testing.T) {
+	components := parseTraceComponents(" shm, gateway ,,watchdog")
+	for _, name := range []string{"shm", "gateway", "watchdog"} {
+		if !components[name] {
+			t.Errorf("expected %q to be a trace component, got %v", name, components)
+		}
+	}
+	if len(components) != 3 {
+		t.Errorf("expected exactly 3 components, got %v", components)
+	}
+
+	if empty := parseTraceComponents(""); len(empty) != 0 {
+		t.Errorf("parseTraceComponents(\"\") = %v, want empty", empty)
+	}
+}
+
+func TestLoggerEnabled(t *testing.T) {
+	origMinLevel, origTraceComponents := minLevel, traceComponents
+	defer func() { minLevel, traceComponents = origMinLevel, origTraceComponents }()
+
+	minLevel = LevelWarn
+	traceComponents = map[string]bool{"shm": true}
+
+	shm := New("shm")
+	gateway := New("gateway")
+
+	if !shm.enabled(LevelWarn) || !shm.enabled(LevelError) {
+		t.Errorf("expected shm logger enabled at or above minLevel=Warn")
+	}
+	if shm.enabled(LevelInfo) || shm.enabled(LevelDebug) {
+		t.Errorf("expected shm logger disabled below minLevel=Warn")
+	}
+
+	// Trace is governed entirely by traceComponents, independent of minLevel.
+	if !shm.enabled(LevelTrace) {
+		t.Errorf("expected shm logger enabled for Trace: it's in traceComponents")
+	}
+	if gateway.enabled(LevelTrace) {
+		t.Errorf("expected gateway logger disabled for Trace: it's not in traceComponents")
+	}
+}
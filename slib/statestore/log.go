@@ -2,21 +2,54 @@ package statestore
 
 import (
 	"encoding/json"
-	"log"
+	"os"
 
+	"cs.utexas.edu/zjia/faas/compression"
+	"cs.utexas.edu/zjia/faas/logging"
 	"cs.utexas.edu/zjia/faas/protocol"
 	"cs.utexas.edu/zjia/faas/types"
 	gabs "github.com/Jeffail/gabs/v2"
 )
 
+var log = logging.New("statestore")
+
+// logCompressionCodec is the codec newly appended ObjectLogEntry records
+// are compressed with. It defaults to whatever BOKI_STATESTORE_COMPRESSION
+// names ("none", "lz4" or "zstd") and can be overridden at runtime via
+// SetCompressionCodec, e.g. by the worker wiring its own --compression_codec
+// flag through to the statestore environment it constructs.
+var logCompressionCodec = mustParseEnvCompressionCodec()
+
+func mustParseEnvCompressionCodec() compression.Codec {
+	codec, err := compression.ParseCodec(os.Getenv("BOKI_STATESTORE_COMPRESSION"))
+	if err != nil {
+		log.Fatalf(nil, "Invalid BOKI_STATESTORE_COMPRESSION: %v", err)
+	}
+	return codec
+}
+
+// SetCompressionCodec overrides the codec used for newly appended
+// ObjectLogEntry records. Existing entries, regardless of codec, keep
+// decoding correctly since decodeLogEntry auto-detects the codec prefix.
+func SetCompressionCodec(codec compression.Codec) {
+	logCompressionCodec = codec
+}
+
 const (
 	LOG_NormalOp = iota
 	LOG_TxnBegin
 	LOG_TxnAbort
 	LOG_TxnCommit
 	LOG_TxnHistory
+	LOG_Snapshot
 )
 
+// kSnapshotCompactionThreshold bounds how many LOG_NormalOp/LOG_TxnCommit
+// entries can accumulate for an object since its last LOG_Snapshot before a
+// new one is appended, so long-lived write-heavy objects don't make every
+// future syncTo walk an ever-growing history.
+const kSnapshotCompactionThreshold = 1000
+
 type ObjectLogEntry struct {
 	seqNum   uint64
 	auxData  map[string]interface{}
@@ -25,6 +58,17 @@ type ObjectLogEntry struct {
 	LogType int        `json:"t"`
 	Ops     []*WriteOp `json:"o,omitempty"`
 	TxnId   uint64     `json:"x"`
+
+	// SnapshotObjName, SnapshotView and SnapshotNextSeqNum are only populated
+	// for LOG_Snapshot entries: the name of the object the snapshot was
+	// taken for, a materialized ObjectView (committed state only) for it,
+	// and the seqNum replay should resume from. SnapshotObjName must be
+	// checked before trusting the rest, since objectLogTag hashes can
+	// collide across object names and a LOG_Snapshot carries no write set
+	// for syncTo to fall back on.
+	SnapshotObjName    string      `json:"s,omitempty"`
+	SnapshotView       interface{} `json:"v,omitempty"`
+	SnapshotNextSeqNum uint64      `json:"n,omitempty"`
 }
 
 const kLogTagReserveBits = 3
@@ -52,7 +96,11 @@ func (l *ObjectLogEntry) fillWriteSet() {
 
 func decodeLogEntry(logEntry *types.LogEntry) *ObjectLogEntry {
 	objectLog := &ObjectLogEntry{}
-	err := json.Unmarshal(logEntry.Data, objectLog)
+	data, err := compression.Decompress(logEntry.Data)
+	if err != nil {
+		panic(err)
+	}
+	err = json.Unmarshal(data, objectLog)
 	if err != nil {
 		panic(err)
 	}
@@ -165,6 +213,13 @@ func (l *ObjectLogEntry) loadCachedObjectView(objName string) *ObjectView {
 	return nil
 }
 
+func (l *ObjectLogEntry) loadSnapshotView() *ObjectView {
+	return &ObjectView{
+		nextSeqNum: l.SnapshotNextSeqNum,
+		contents:   gabs.Wrap(l.SnapshotView),
+	}
+}
+
 func (l *ObjectLogEntry) cacheObjectView(env *envImpl, objName string, view *ObjectView) {
 	if l.LogType == LOG_NormalOp {
 		if l.auxData == nil {
@@ -190,12 +245,13 @@ func (obj *ObjectRef) syncTo(tailSeqNum uint64) error {
 	env := obj.env
 	objectLogs := make([]*ObjectLogEntry, 0, 4)
 	var view *ObjectView
+	var snapshotView *ObjectView
 	seqNum := tailSeqNum
 	currentSeqNum := uint64(0)
 	if obj.view != nil {
 		currentSeqNum = obj.view.nextSeqNum
 		if tailSeqNum < currentSeqNum {
-			log.Fatalf("[FATAL] Current seqNum=%#016x, cannot sync to %#016x", currentSeqNum, tailSeqNum)
+			log.Fatalf(logging.Fields{"obj": obj.name}, "Current seqNum=%#016x, cannot sync to %#016x", currentSeqNum, tailSeqNum)
 		}
 	}
 	if tailSeqNum == currentSeqNum {
@@ -216,6 +272,22 @@ func (obj *ObjectRef) syncTo(tailSeqNum uint64) error {
 		seqNum = logEntry.SeqNum
 		// log.Printf("[DEBUG] Read log with seqnum %#016x", seqNum)
 		objectLog := decodeLogEntry(logEntry)
+		if objectLog.LogType == LOG_Snapshot {
+			if objectLog.SnapshotObjName != obj.name || snapshotView != nil {
+				// objectLogTag hashes can collide across object names, so
+				// this may be another object's snapshot; an older snapshot
+				// of obj is superseded by the one already found.
+				continue
+			}
+			obj.opsSinceSnapshot = 0
+			if snapshot := objectLog.loadSnapshotView(); snapshot.nextSeqNum > currentSeqNum {
+				// Entries from the snapshot's nextSeqNum up to the snapshot
+				// itself are not in it, so keep reading back to there.
+				snapshotView = snapshot
+				currentSeqNum = snapshot.nextSeqNum
+			}
+			continue
+		}
 		if !objectLog.withinWriteSet(obj.name) {
 			continue
 		}
@@ -236,7 +308,9 @@ func (obj *ObjectRef) syncTo(tailSeqNum uint64) error {
 	}
 
 	if view == nil {
-		if obj.view != nil {
+		if snapshotView != nil {
+			view = snapshotView
+		} else if obj.view != nil {
 			view = obj.view
 		} else {
 			view = &ObjectView{
@@ -248,7 +322,7 @@ func (obj *ObjectRef) syncTo(tailSeqNum uint64) error {
 	for i := len(objectLogs) - 1; i >= 0; i-- {
 		objectLog := objectLogs[i]
 		if objectLog.seqNum < view.nextSeqNum {
-			log.Fatalf("[FATAL] LogSeqNum=%#016x, ViewNextSeqNum=%#016x", objectLog.seqNum, view.nextSeqNum)
+			log.Fatalf(logging.Fields{"obj": obj.name, "seqNum": objectLog.seqNum}, "LogSeqNum=%#016x, ViewNextSeqNum=%#016x", objectLog.seqNum, view.nextSeqNum)
 		}
 		view.nextSeqNum = objectLog.seqNum + 1
 		for _, op := range objectLog.Ops {
@@ -266,6 +340,45 @@ func (obj *ObjectRef) Sync() error {
 	return obj.syncTo(protocol.MaxLogSeqnum)
 }
 
+// ForceSnapshot appends a LOG_Snapshot entry capturing obj's current
+// (committed) view, so later syncTo calls can stop here instead of
+// replaying obj's full history. appendNormalOpLog calls this automatically
+// once kSnapshotCompactionThreshold is exceeded; callers needing an
+// off-cycle checkpoint (e.g. tests) can also call it directly.
+func (obj *ObjectRef) ForceSnapshot() error {
+	if obj.view == nil {
+		if err := obj.Sync(); err != nil {
+			return err
+		}
+	}
+	_, err := obj.appendSnapshotLog()
+	return err
+}
+
+func (obj *ObjectRef) appendSnapshotLog() (uint64 /* seqNum */, error) {
+	logEntry := &ObjectLogEntry{
+		LogType:            LOG_Snapshot,
+		SnapshotObjName:    obj.name,
+		SnapshotView:       obj.view.contents.Data(),
+		SnapshotNextSeqNum: obj.view.nextSeqNum,
+	}
+	encoded, err := json.Marshal(logEntry)
+	if err != nil {
+		panic(err)
+	}
+	encoded, err = compression.Compress(logCompressionCodec, compression.DefaultThreshold, encoded)
+	if err != nil {
+		panic(err)
+	}
+	tag := objectLogTag(obj.nameHash)
+	seqNum, err := obj.env.faasEnv.SharedLogAppend(obj.env.faasCtx, []uint64{tag}, encoded)
+	if err != nil {
+		return 0, newRuntimeError(err.Error())
+	}
+	obj.opsSinceSnapshot = 0
+	return seqNum, nil
+}
+
 func (obj *ObjectRef) appendNormalOpLog(ops []*WriteOp) (uint64 /* seqNum */, error) {
 	if len(ops) == 0 {
 		panic("Empty Ops for NormalOp log")
@@ -278,13 +391,22 @@ func (obj *ObjectRef) appendNormalOpLog(ops []*WriteOp) (uint64 /* seqNum */, er
 	if err != nil {
 		panic(err)
 	}
+	encoded, err = compression.Compress(logCompressionCodec, compression.DefaultThreshold, encoded)
+	if err != nil {
+		panic(err)
+	}
 	tag := objectLogTag(obj.nameHash)
 	seqNum, err := obj.env.faasEnv.SharedLogAppend(obj.env.faasCtx, []uint64{tag}, encoded)
 	if err != nil {
 		return 0, newRuntimeError(err.Error())
-	} else {
-		return seqNum, nil
 	}
+	obj.opsSinceSnapshot += len(ops)
+	if obj.opsSinceSnapshot > kSnapshotCompactionThreshold {
+		if err := obj.ForceSnapshot(); err != nil {
+			log.Errorf(logging.Fields{"obj": obj.name}, "Failed to snapshot object: %v", err)
+		}
+	}
+	return seqNum, nil
 }
 
 func (obj *ObjectRef) appendWriteLog(op *WriteOp) (uint64 /* seqNum */, error) {
@@ -297,6 +419,10 @@ func (env *envImpl) appendTxnBeginLog() (uint64 /* seqNum */, error) {
 	if err != nil {
 		panic(err)
 	}
+	encoded, err = compression.Compress(logCompressionCodec, compression.DefaultThreshold, encoded)
+	if err != nil {
+		panic(err)
+	}
 	seqNum, err := env.faasEnv.SharedLogAppend(env.faasCtx, []uint64{kTxnMetaLogTag}, encoded)
 	if err != nil {
 		return 0, newRuntimeError(err.Error())
@@ -318,4 +444,4 @@ func (env *envImpl) setLogAuxData(seqNum uint64, data interface{}) error {
 		// log.Printf("[DEBUG] Set AuxData for log (seqNum=%#016x): contents=%s", seqNum, string(encoded))
 		return nil
 	}
-}
\ No newline at end of file
+}
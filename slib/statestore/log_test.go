@@ -0,0 +1,99 @@
+package statestore
+
+import (
+	"encoding/json"
+	"testing"
+
+	"cs.utexas.edu/zjia/faas/compression"
+	"cs.utexas.edu/zjia/faas/types"
+)
+
+func encodeForTest(t *testing.T, codec compression.Codec, entry *ObjectLogEntry) *types.LogEntry {
+	t.Helper()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	// Threshold 0 so LZ4 and Zstd really compress these small entries.
+	data, err = compression.Compress(codec, 0, data)
+	if err != nil {
+		t.Fatalf("compression.Compress failed: %v", err)
+	}
+	return &types.LogEntry{Data: data, SeqNum: 42}
+}
+
+func TestDecodeLogEntryRoundTripsThroughCompression(t *testing.T) {
+	for _, codec := range []compression.Codec{compression.Raw, compression.LZ4, compression.Zstd} {
+		entry := &ObjectLogEntry{
+			LogType: LOG_NormalOp,
+			Ops:     []*WriteOp{{ObjName: "obj-a"}, {ObjName: "obj-b"}},
+		}
+		logEntry := encodeForTest(t, codec, entry)
+		decoded := decodeLogEntry(logEntry)
+		if decoded.LogType != LOG_NormalOp {
+			t.Fatalf("codec=%v: LogType = %d, want LOG_NormalOp", codec, decoded.LogType)
+		}
+		if len(decoded.Ops) != 2 || decoded.Ops[0].ObjName != "obj-a" || decoded.Ops[1].ObjName != "obj-b" {
+			t.Fatalf("codec=%v: Ops round trip mismatch: %+v", codec, decoded.Ops)
+		}
+		if !decoded.withinWriteSet("obj-a") || !decoded.withinWriteSet("obj-b") {
+			t.Fatalf("codec=%v: expected write set to contain both objects", codec)
+		}
+		if decoded.withinWriteSet("obj-c") {
+			t.Fatalf("codec=%v: did not expect obj-c in write set", codec)
+		}
+	}
+}
+
+func TestLoadSnapshotView(t *testing.T) {
+	entry := &ObjectLogEntry{
+		LogType:            LOG_Snapshot,
+		SnapshotObjName:    "obj-a",
+		SnapshotView:       map[string]interface{}{"count": float64(3)},
+		SnapshotNextSeqNum: 7,
+	}
+	view := entry.loadSnapshotView()
+	if view.nextSeqNum != 7 {
+		t.Fatalf("nextSeqNum = %d, want 7", view.nextSeqNum)
+	}
+	if count, ok := view.contents.Data().(map[string]interface{})["count"]; !ok || count != float64(3) {
+		t.Fatalf("unexpected snapshot contents: %+v", view.contents.Data())
+	}
+}
+
+func TestSnapshotObjNameRoundTrips(t *testing.T) {
+	// objectLogTag hashes can collide across object names; syncTo relies on
+	// SnapshotObjName (not just the shared tag) to tell whose snapshot it's
+	// looking at, so it must survive encode/decode untouched.
+	entry := &ObjectLogEntry{LogType: LOG_Snapshot, SnapshotObjName: "obj-a"}
+	logEntry := encodeForTest(t, compression.Raw, entry)
+	decoded := decodeLogEntry(logEntry)
+	if decoded.SnapshotObjName != "obj-a" {
+		t.Fatalf("SnapshotObjName = %q, want %q", decoded.SnapshotObjName, "obj-a")
+	}
+}
+
+func TestWriteSetOverlapped(t *testing.T) {
+	a := &ObjectLogEntry{LogType: LOG_NormalOp, Ops: []*WriteOp{{ObjName: "x"}}}
+	a.fillWriteSet()
+	b := &ObjectLogEntry{LogType: LOG_NormalOp, Ops: []*WriteOp{{ObjName: "y"}}}
+	b.fillWriteSet()
+	if a.writeSetOverlapped(b) {
+		t.Fatalf("expected no overlap between disjoint write sets")
+	}
+	c := &ObjectLogEntry{LogType: LOG_NormalOp, Ops: []*WriteOp{{ObjName: "x"}, {ObjName: "z"}}}
+	c.fillWriteSet()
+	if !a.writeSetOverlapped(c) {
+		t.Fatalf("expected overlap on shared object x")
+	}
+}
+
+func TestSetCompressionCodec(t *testing.T) {
+	original := logCompressionCodec
+	defer SetCompressionCodec(original)
+
+	SetCompressionCodec(compression.Zstd)
+	if logCompressionCodec != compression.Zstd {
+		t.Fatalf("SetCompressionCodec did not take effect: got %v", logCompressionCodec)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cs.utexas.edu/zjia/faas/compression"
+	"cs.utexas.edu/zjia/faas/logging"
+	"cs.utexas.edu/zjia/faas/slib/statestore"
+)
+
+var mainLog = logging.New("main")
+
+func parseFuncLibraries(spec string) ([]FuncLibraryConfig, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	entries := strings.Split(spec, ",")
+	libraries := make([]FuncLibraryConfig, 0, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid func_libraries entry %q, want func_id:path", entry)
+		}
+		funcId, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid func_id in func_libraries entry %q: %v", entry, err)
+		}
+		libraries = append(libraries, FuncLibraryConfig{FuncId: uint16(funcId), Path: parts[1]})
+	}
+	return libraries, nil
+}
+
+func main() {
+	funcConfigPath := flag.String("func_config_path", "", "Path to the function config file")
+	gatewayIpcAddr := flag.String("gateway_ipc_addr", "", "Unix IPC address of the gateway")
+	inputPipeFd := flag.Int("input_pipe_fd", -1, "Watchdog input pipe file descriptor")
+	outputPipeFd := flag.Int("output_pipe_fd", -1, "Watchdog output pipe file descriptor")
+	shmBasePath := flag.String("shm_base_path", "/dev/shm/faas", "Base path for shared memory files")
+
+	gatewayReadTimeout := flag.Duration("gateway_read_timeout", DefaultGatewayReadTimeout, "Timeout for reads on the gateway IPC socket")
+	gatewayWriteTimeout := flag.Duration("gateway_write_timeout", DefaultGatewayWriteTimeout, "Timeout for writes on the gateway IPC socket")
+	watchdogReadTimeout := flag.Duration("watchdog_read_timeout", DefaultWatchdogReadTimeout, "Timeout for reads on the watchdog pipe")
+	invokeTimeout := flag.Duration("invoke_timeout", DefaultInvokeTimeout, "Timeout for a single function invocation")
+
+	compressionCodec := flag.String("compression_codec", "", "Compression codec for shm payloads (none, lz4, zstd)")
+	compressionThreshold := flag.Int("compression_threshold", compression.DefaultThreshold, "Smallest payload size worth compressing")
+
+	funcLibraries := flag.String("func_libraries", "", "Comma-separated func_id:path entries this worker hosts")
+	concurrencyCap := flag.Int("concurrency_cap", DefaultConcurrencyCap, "Max function calls in flight across all hosted func_ids")
+	perFuncConcurrency := flag.Int("per_func_concurrency", DefaultPerFuncConcurrency, "Max function calls in flight per hosted func_id")
+
+	flag.Parse()
+
+	codec, err := compression.ParseCodec(*compressionCodec)
+	if err != nil {
+		mainLog.Fatalf(nil, "Failed to parse compression_codec: %v", err)
+	}
+	libraries, err := parseFuncLibraries(*funcLibraries)
+	if err != nil {
+		mainLog.Fatalf(nil, "Failed to parse func_libraries: %v", err)
+	}
+	// Function libraries are dlopen'd into this same process, so
+	// --compression_codec governs shm payload compression here and
+	// statestore log-entry compression for whatever they SharedLogAppend.
+	statestore.SetCompressionCodec(codec)
+
+	config := WorkerConfig{
+		funcConfigPath: *funcConfigPath,
+		gatewayIpcAddr: *gatewayIpcAddr,
+		inputPipeFd:    *inputPipeFd,
+		outputPipeFd:   *outputPipeFd,
+		shmBasePath:    *shmBasePath,
+
+		GatewayReadTimeout:  *gatewayReadTimeout,
+		GatewayWriteTimeout: *gatewayWriteTimeout,
+		WatchdogReadTimeout: *watchdogReadTimeout,
+		InvokeTimeout:       *invokeTimeout,
+
+		CompressionCodec:     codec,
+		CompressionThreshold: *compressionThreshold,
+
+		FuncLibraries:      libraries,
+		ConcurrencyCap:     *concurrencyCap,
+		PerFuncConcurrency: *perFuncConcurrency,
+	}
+
+	w, err := newWorker(config)
+	if err != nil {
+		mainLog.Fatalf(nil, "Failed to create worker: %v", err)
+	}
+	if err := w.handshakeWithGateway(); err != nil {
+		mainLog.Fatalf(nil, "Failed to handshake with gateway: %v", err)
+	}
+	w.serve()
+	w.waitForFinish()
+}
@@ -1,32 +1,122 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"cs.utexas.edu/zjia/faas/compression"
+	"cs.utexas.edu/zjia/faas/logging"
+)
+
+var (
+	shmLog      = logging.New("shm")
+	gatewayLog  = logging.New("gateway")
+	watchdogLog = logging.New("watchdog")
+)
+
+const (
+	DefaultGatewayReadTimeout  = 10 * time.Second
+	DefaultGatewayWriteTimeout = 10 * time.Second
+	DefaultWatchdogReadTimeout = 10 * time.Second
+	DefaultInvokeTimeout       = 30 * time.Second
+
+	// DefaultConcurrencyCap bounds the number of function calls in flight
+	// across all funcIds a worker process hosts; DefaultPerFuncConcurrency
+	// bounds it per funcId on top of that.
+	DefaultConcurrencyCap     = 64
+	DefaultPerFuncConcurrency = 16
 )
 
+// FuncLibraryConfig names one dlopen-able function library this worker
+// process can host, keyed by funcId. Libraries are loaded lazily, on first
+// invocation of their funcId, not eagerly at startup.
+type FuncLibraryConfig struct {
+	FuncId uint16
+	Path   string
+}
+
 type WorkerConfig struct {
-	funcLibraryPath string
-	funcConfigPath  string
-	gatewayIpcAddr  string
-	funcId          int
-	inputPipeFd     int
-	outputPipeFd    int
-	shmBasePath     string
+	funcConfigPath string
+	gatewayIpcAddr string
+	inputPipeFd    int
+	outputPipeFd   int
+	shmBasePath    string
+
+	// GatewayReadTimeout and GatewayWriteTimeout bound individual reads and
+	// writes on the Unix IPC socket to the gateway. WatchdogReadTimeout
+	// bounds reads on the watchdog pipe. InvokeTimeout bounds the total time
+	// invokeFunc waits for a result before cancelling the in-flight call.
+	// Zero means "use the default", not "no timeout".
+	GatewayReadTimeout  time.Duration
+	GatewayWriteTimeout time.Duration
+	WatchdogReadTimeout time.Duration
+	InvokeTimeout       time.Duration
+
+	// CompressionCodec selects how shm-backed function input/output is
+	// encoded ("none", "lz4" or "zstd"); payloads no larger than
+	// CompressionThreshold bytes are always stored raw.
+	CompressionCodec     compression.Codec
+	CompressionThreshold int
+
+	// FuncLibraries lists the funcIds this worker process hosts. A single
+	// worker multiplexes calls to all of them instead of pinning one
+	// funcId per process, which is what made cold starts expensive before.
+	FuncLibraries      []FuncLibraryConfig
+	ConcurrencyCap     int
+	PerFuncConcurrency int
+}
+
+func (config *WorkerConfig) setDefaults() {
+	if config.GatewayReadTimeout == 0 {
+		config.GatewayReadTimeout = DefaultGatewayReadTimeout
+	}
+	if config.GatewayWriteTimeout == 0 {
+		config.GatewayWriteTimeout = DefaultGatewayWriteTimeout
+	}
+	if config.WatchdogReadTimeout == 0 {
+		config.WatchdogReadTimeout = DefaultWatchdogReadTimeout
+	}
+	if config.InvokeTimeout == 0 {
+		config.InvokeTimeout = DefaultInvokeTimeout
+	}
+	if config.CompressionThreshold == 0 {
+		config.CompressionThreshold = compression.DefaultThreshold
+	}
+	if config.ConcurrencyCap == 0 {
+		config.ConcurrencyCap = DefaultConcurrencyCap
+	}
+	if config.PerFuncConcurrency == 0 {
+		config.PerFuncConcurrency = DefaultPerFuncConcurrency
+	}
+}
+
+// hostedFuncLibrary is one funcId this worker is configured to serve: the
+// dlopen path it should lazily load from, the loaded library once it has
+// (guarded by loadOnce), and a bounded pool limiting how many calls to this
+// funcId can run concurrently.
+type hostedFuncLibrary struct {
+	path     string
+	inFlight chan struct{}
+
+	loadOnce sync.Once
+	library  *FuncLibrary
+	loadErr  error
 }
 
 type Worker struct {
-	funcLibrary        *FuncLibrary
-	funcId             uint16
 	funcConfig         *FuncConfig
+	funcLibraries      sync.Map // funcId uint16 -> *hostedFuncLibrary
+	globalInFlight     chan struct{}
 	shm                *SharedMemory
 	gateway            *GatewayEndpoint
 	watchdog           *WatchdogEndpoint
 	wg                 sync.WaitGroup
 	nextCallId         uint32
 	funcInvokeContexts sync.Map
+	config             WorkerConfig
 }
 
 type FuncInvokeContext struct {
@@ -34,32 +124,40 @@ type FuncInvokeContext struct {
 }
 
 func newWorker(config WorkerConfig) (*Worker, error) {
+	config.setDefaults()
 	w := new(Worker)
-	funcLibrary, err := newFuncLibrary(config.funcLibraryPath)
-	if err != nil {
-		return nil, err
-	}
-	w.funcLibrary = funcLibrary
-	w.funcId = uint16(config.funcId)
+	w.config = config
 	funcConfig, err := newFuncConfig(config.funcConfigPath)
 	if err != nil {
 		return nil, err
 	}
 	w.funcConfig = funcConfig
-	if w.funcConfig.findByFuncId(w.funcId) == nil {
-		return nil, fmt.Errorf("Cannot find func_id %d in func_config file", w.funcId)
+	if len(config.FuncLibraries) == 0 {
+		return nil, fmt.Errorf("No func_libraries configured: worker would handshake successfully but reject every invocation")
+	}
+	funcIds := make([]uint16, 0, len(config.FuncLibraries))
+	for _, libConfig := range config.FuncLibraries {
+		if w.funcConfig.findByFuncId(libConfig.FuncId) == nil {
+			return nil, fmt.Errorf("Cannot find func_id %d in func_config file", libConfig.FuncId)
+		}
+		w.funcLibraries.Store(libConfig.FuncId, &hostedFuncLibrary{
+			path:     libConfig.Path,
+			inFlight: make(chan struct{}, config.PerFuncConcurrency),
+		})
+		funcIds = append(funcIds, libConfig.FuncId)
 	}
+	w.globalInFlight = make(chan struct{}, config.ConcurrencyCap)
 	shm, err := newSharedMemory(config.shmBasePath)
 	if err != nil {
 		return nil, err
 	}
 	w.shm = shm
-	gateway, err := newGatewayEndpoint(w, config.gatewayIpcAddr)
+	gateway, err := newGatewayEndpoint(w, config.gatewayIpcAddr, funcIds, config.GatewayReadTimeout, config.GatewayWriteTimeout)
 	if err != nil {
 		return nil, err
 	}
 	w.gateway = gateway
-	watchdog, err := newWatchdogEndpoint(w, config.inputPipeFd, config.outputPipeFd)
+	watchdog, err := newWatchdogEndpoint(w, config.inputPipeFd, config.outputPipeFd, config.WatchdogReadTimeout)
 	if err != nil {
 		gateway.close()
 		return nil, err
@@ -73,9 +171,6 @@ func (w *Worker) handshakeWithGateway() error {
 }
 
 func (w *Worker) serve() {
-	w.funcLibrary.init(func(funcName string, input []byte) ([]byte, error) {
-		return w.invokeFunc(funcName, input)
-	})
 	w.gateway.startRoutines()
 	w.watchdog.startRoutines()
 }
@@ -84,34 +179,105 @@ func (w *Worker) waitForFinish() {
 	w.wg.Wait()
 }
 
+// loadFuncLibrary returns the dlopen'd FuncLibrary hosting funcId, loading
+// it on first use. Concurrent callers for the same funcId block on the
+// same sync.Once rather than racing separate dlopen calls.
+func (w *Worker) loadFuncLibrary(funcId uint16) (*FuncLibrary, error) {
+	value, exist := w.funcLibraries.Load(funcId)
+	if !exist {
+		return nil, fmt.Errorf("Worker does not host func_id %d", funcId)
+	}
+	hosted := value.(*hostedFuncLibrary)
+	hosted.loadOnce.Do(func() {
+		hosted.library, hosted.loadErr = newFuncLibrary(hosted.path)
+		if hosted.loadErr != nil {
+			return
+		}
+		hosted.library.init(func(funcName string, input []byte) ([]byte, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), w.config.InvokeTimeout)
+			defer cancel()
+			return w.invokeFunc(ctx, funcName, input)
+		})
+	})
+	return hosted.library, hosted.loadErr
+}
+
+func shmKey(funcCall FuncCall, suffix string) string {
+	return fmt.Sprintf("%d.%d.%s", funcCall.funcId, fullFuncCallId(funcCall), suffix)
+}
+
 func (w *Worker) runFuncHandler(funcCall FuncCall) bool {
-	inputShm, err := w.shm.openReadOnly(fmt.Sprintf("%d.i", fullFuncCallId(funcCall)))
+	fields := logging.Fields{"funcId": funcCall.funcId, "callId": funcCall.callId, "clientId": funcCall.clientId}
+	value, exist := w.funcLibraries.Load(funcCall.funcId)
+	if !exist {
+		watchdogLog.Errorf(fields, "Worker does not host func_id %d", funcCall.funcId)
+		return false
+	}
+	hosted := value.(*hostedFuncLibrary)
+
+	select {
+	case w.globalInFlight <- struct{}{}:
+	default:
+		watchdogLog.Warnf(fields, "Rejecting call: worker-wide concurrency cap reached")
+		return false
+	}
+	defer func() { <-w.globalInFlight }()
+
+	select {
+	case hosted.inFlight <- struct{}{}:
+	default:
+		watchdogLog.Warnf(fields, "Rejecting call: per-func_id concurrency cap reached")
+		return false
+	}
+	defer func() { <-hosted.inFlight }()
+
+	library, err := w.loadFuncLibrary(funcCall.funcId)
+	if err != nil {
+		watchdogLog.Errorf(fields, "Failed to load function library for func_id %d: %v", funcCall.funcId, err)
+		return false
+	}
+
+	inputShm, err := w.shm.openReadOnly(shmKey(funcCall, "i"))
 	if err != nil {
-		log.Print("[ERROR] Failed to open shared memory: ", err)
+		shmLog.Errorf(fields, "Failed to open shared memory: %v", err)
 		return false
 	}
-	input := make([]byte, len(inputShm))
-	if copy(input, inputShm) != len(inputShm) {
-		log.Fatal("[FATAL] Failed to copy input from shared memory")
+	rawInput := make([]byte, len(inputShm))
+	if copy(rawInput, inputShm) != len(inputShm) {
+		shmLog.Errorf(fields, "Failed to copy input from shared memory")
+		w.shm.close(inputShm)
+		return false
 	}
 	w.shm.close(inputShm)
-	output, err := w.funcLibrary.funcCall(input)
+	input, err := compression.Decompress(rawInput)
+	if err != nil {
+		shmLog.Errorf(fields, "Failed to decompress input: %v", err)
+		return false
+	}
+	output, err := library.funcCall(input)
+	if err != nil {
+		return false
+	}
+	compressedOutput, err := compression.Compress(w.config.CompressionCodec, w.config.CompressionThreshold, output)
 	if err != nil {
+		shmLog.Errorf(fields, "Failed to compress output: %v", err)
 		return false
 	}
-	outputShm, err := w.shm.create(fmt.Sprintf("%d.o", fullFuncCallId(funcCall)), len(output))
+	outputShm, err := w.shm.create(shmKey(funcCall, "o"), len(compressedOutput))
 	if err != nil {
-		log.Print("[ERROR] Failed to create shared memory: ", err)
+		shmLog.Errorf(fields, "Failed to create shared memory: %v", err)
 		return false
 	}
-	if copy(outputShm, output) != len(output) {
-		log.Fatal("[FATAL] Failed to copy output to shared memory")
+	if copy(outputShm, compressedOutput) != len(compressedOutput) {
+		shmLog.Errorf(fields, "Failed to copy output to shared memory")
+		w.shm.close(outputShm)
+		return false
 	}
 	w.shm.close(outputShm)
 	return true
 }
 
-func (w *Worker) invokeFunc(funcName string, input []byte) ([]byte, error) {
+func (w *Worker) invokeFunc(ctx context.Context, funcName string, input []byte) ([]byte, error) {
 	funcConfigEntry := w.funcConfig.findByFuncName(funcName)
 	if funcConfigEntry == nil {
 		return nil, fmt.Errorf("Cannot find function with name %s", funcName)
@@ -124,48 +290,70 @@ func (w *Worker) invokeFunc(funcName string, input []byte) ([]byte, error) {
 		clientId: w.gateway.clientId,
 		callId:   atomic.AddUint32(&w.nextCallId, 1),
 	}
-	inputShm, err := w.shm.create(fmt.Sprintf("%d.i", fullFuncCallId(funcCall)), len(input))
+	fields := logging.Fields{"funcId": funcCall.funcId, "callId": funcCall.callId, "clientId": funcCall.clientId}
+	compressedInput, err := compression.Compress(w.config.CompressionCodec, w.config.CompressionThreshold, input)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to compress input: %w", err)
+	}
+	inputShm, err := w.shm.create(shmKey(funcCall, "i"), len(compressedInput))
 	if err != nil {
-		log.Print("[ERROR] Failed to open shared memory: ", err)
+		shmLog.Errorf(fields, "Failed to open shared memory: %v", err)
 		return nil, fmt.Errorf("Internal error")
 	}
-	if copy(inputShm, input) != len(input) {
-		log.Fatal("[FATAL] Failed to copy input to shared memory")
+	if copy(inputShm, compressedInput) != len(compressedInput) {
+		shmLog.Errorf(fields, "Failed to copy input to shared memory")
+		w.shm.close(inputShm)
+		return nil, fmt.Errorf("Failed to copy input to shared memory")
 	}
 	w.shm.close(inputShm)
-	context := new(FuncInvokeContext)
-	context.resultChan = make(chan bool)
-	w.funcInvokeContexts.Store(fullFuncCallId(funcCall), context)
+	invokeContext := new(FuncInvokeContext)
+	invokeContext.resultChan = make(chan bool, 1)
+	w.funcInvokeContexts.Store(fullFuncCallId(funcCall), invokeContext)
 	w.gateway.writeMessage(Message{
 		messageType: MessageType_INVOKE_FUNC,
 		funcCall:    funcCall,
 	})
-	success := <-context.resultChan
-	close(context.resultChan)
+	var success bool
+	select {
+	case success = <-invokeContext.resultChan:
+	case <-ctx.Done():
+		w.funcInvokeContexts.Delete(fullFuncCallId(funcCall))
+		w.shm.remove(shmKey(funcCall, "i"))
+		w.shm.remove(shmKey(funcCall, "o"))
+		return nil, fmt.Errorf("Function call timed out: %w", ctx.Err())
+	}
 	w.funcInvokeContexts.Delete(fullFuncCallId(funcCall))
-	w.shm.remove(fmt.Sprintf("%d.i", fullFuncCallId(funcCall)))
+	w.shm.remove(shmKey(funcCall, "i"))
 	if !success {
 		return nil, fmt.Errorf("Function call failed")
 	}
-	outputShm, err := w.shm.openReadOnly(fmt.Sprintf("%d.o", fullFuncCallId(funcCall)))
+	outputShm, err := w.shm.openReadOnly(shmKey(funcCall, "o"))
 	if err != nil {
-		log.Print("[ERROR] Failed to open shared memory: ", err)
+		shmLog.Errorf(fields, "Failed to open shared memory: %v", err)
 		return nil, fmt.Errorf("Internal error")
 	}
-	output := make([]byte, len(outputShm))
-	if copy(output, outputShm) != len(outputShm) {
-		log.Fatal("[FATAL] Failed to copy output from shared memory")
+	rawOutput := make([]byte, len(outputShm))
+	if copy(rawOutput, outputShm) != len(outputShm) {
+		shmLog.Errorf(fields, "Failed to copy output from shared memory")
+		w.shm.close(outputShm)
+		w.shm.remove(shmKey(funcCall, "o"))
+		return nil, fmt.Errorf("Failed to copy output from shared memory")
 	}
 	w.shm.close(outputShm)
-	w.shm.remove(fmt.Sprintf("%d.o", fullFuncCallId(funcCall)))
+	w.shm.remove(shmKey(funcCall, "o"))
+	output, err := compression.Decompress(rawOutput)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decompress output: %w", err)
+	}
 	return output, nil
 }
 
+// onWatchdogMessage dispatches an incoming call to whichever hosted funcId
+// it targets; runFuncHandler looks up (and lazily loads) that funcId's
+// library, so a single worker process can serve calls for several funcIds
+// concurrently instead of being pinned to one.
 func (w *Worker) onWatchdogMessage(message Message) {
 	if message.messageType == MessageType_INVOKE_FUNC {
-		if message.funcCall.funcId != w.funcId {
-			log.Fatalf("[FATAL] Cannot invoke function of func_id %d", message.funcCall.funcId)
-		}
 		go func() {
 			success := w.runFuncHandler(message.funcCall)
 			var response Message
@@ -181,15 +369,21 @@ func (w *Worker) onWatchdogMessage(message Message) {
 			w.watchdog.writeMessage(response)
 		}()
 	} else {
-		log.Print("[ERROR] Unknown message type")
+		watchdogLog.Errorf(nil, "Unknown message type")
 	}
 }
 
 func (w *Worker) onGatewayMessage(message Message) {
 	if message.messageType == MessageType_FUNC_CALL_COMPLETE || message.messageType == MessageType_FUNC_CALL_FAILED {
+		fields := logging.Fields{"funcId": message.funcCall.funcId, "callId": message.funcCall.callId, "clientId": message.funcCall.clientId}
 		value, exist := w.funcInvokeContexts.Load(fullFuncCallId(message.funcCall))
 		if !exist {
-			log.Printf("[ERROR] Cannot find InvokeContext for call_id %d", fullFuncCallId(message.funcCall))
+			// invokeFunc already gave up on ctx.Done() and removed the "i"
+			// and "o" shm keys, but the callee hadn't created "o" yet at
+			// that point, so this late completion just now created it.
+			// Remove it here instead of leaking it.
+			gatewayLog.Warnf(fields, "InvokeContext for call_id %d already gone (timed out?), cleaning up its output shm", fullFuncCallId(message.funcCall))
+			w.shm.remove(shmKey(message.funcCall, "o"))
 			return
 		}
 		context := value.(*FuncInvokeContext)
@@ -199,7 +393,7 @@ func (w *Worker) onGatewayMessage(message Message) {
 			context.resultChan <- false
 		}
 	} else {
-		log.Print("[ERROR] Unknown message type")
+		gatewayLog.Errorf(nil, "Unknown message type")
 	}
 }
 
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestShmKeyIncludesFuncId(t *testing.T) {
+	funcCall := FuncCall{funcId: 7, clientId: 3, callId: 5}
+	key := shmKey(funcCall, "i")
+	want := fmt.Sprintf("7.%d.i", fullFuncCallId(funcCall))
+	if key != want {
+		t.Fatalf("shmKey = %q, want %q", key, want)
+	}
+}
+
+func TestWorkerConfigSetDefaults(t *testing.T) {
+	config := WorkerConfig{}
+	config.setDefaults()
+
+	if config.GatewayReadTimeout != DefaultGatewayReadTimeout {
+		t.Errorf("GatewayReadTimeout = %v, want %v", config.GatewayReadTimeout, DefaultGatewayReadTimeout)
+	}
+	if config.GatewayWriteTimeout != DefaultGatewayWriteTimeout {
+		t.Errorf("GatewayWriteTimeout = %v, want %v", config.GatewayWriteTimeout, DefaultGatewayWriteTimeout)
+	}
+	if config.WatchdogReadTimeout != DefaultWatchdogReadTimeout {
+		t.Errorf("WatchdogReadTimeout = %v, want %v", config.WatchdogReadTimeout, DefaultWatchdogReadTimeout)
+	}
+	if config.InvokeTimeout != DefaultInvokeTimeout {
+		t.Errorf("InvokeTimeout = %v, want %v", config.InvokeTimeout, DefaultInvokeTimeout)
+	}
+	if config.ConcurrencyCap != DefaultConcurrencyCap {
+		t.Errorf("ConcurrencyCap = %d, want %d", config.ConcurrencyCap, DefaultConcurrencyCap)
+	}
+	if config.PerFuncConcurrency != DefaultPerFuncConcurrency {
+		t.Errorf("PerFuncConcurrency = %d, want %d", config.PerFuncConcurrency, DefaultPerFuncConcurrency)
+	}
+
+	// A non-zero timeout set by the caller must be left untouched.
+	config2 := WorkerConfig{InvokeTimeout: 5 * time.Second}
+	config2.setDefaults()
+	if config2.InvokeTimeout != 5*time.Second {
+		t.Errorf("InvokeTimeout = %v, want 5s (caller-provided value overwritten)", config2.InvokeTimeout)
+	}
+}
+
+func TestParseFuncLibraries(t *testing.T) {
+	libraries, err := parseFuncLibraries("1:/libs/a.so,2:/libs/b.so")
+	if err != nil {
+		t.Fatalf("parseFuncLibraries failed: %v", err)
+	}
+	want := []FuncLibraryConfig{{FuncId: 1, Path: "/libs/a.so"}, {FuncId: 2, Path: "/libs/b.so"}}
+	if len(libraries) != len(want) {
+		t.Fatalf("got %d libraries, want %d", len(libraries), len(want))
+	}
+	for i := range want {
+		if libraries[i] != want[i] {
+			t.Errorf("libraries[%d] = %+v, want %+v", i, libraries[i], want[i])
+		}
+	}
+
+	if _, err := parseFuncLibraries("bogus"); err == nil {
+		t.Fatalf("expected error for entry missing a path")
+	}
+	if _, err := parseFuncLibraries("notanumber:/libs/a.so"); err == nil {
+		t.Fatalf("expected error for non-numeric func_id")
+	}
+}